@@ -4,11 +4,17 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bakito/adguardhome-sync/pkg/client/model"
 	"github.com/bakito/adguardhome-sync/pkg/log"
@@ -19,10 +25,26 @@ import (
 
 const envRedirectPolicyNoOfRedirects = "REDIRECT_POLICY_NO_OF_REDIRECTS"
 
+const (
+	envMaxRetries       = "CLIENT_MAX_RETRIES"
+	envRetryBaseDelay   = "CLIENT_RETRY_BASE_DELAY"
+	envRPS              = "CLIENT_RPS"
+	envBreakerThreshold = "CLIENT_BREAKER_THRESHOLD"
+	envBreakerWindow    = "CLIENT_BREAKER_WINDOW"
+
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultBreakerWindow  = 30 * time.Second
+)
+
 var (
 	l = log.GetLogger("client")
 	// ErrSetupNeeded custom error
 	ErrSetupNeeded = errors.New("setup needed")
+	// errReauthenticateNotSupported is returned by AuthProvider implementations
+	// backed by a static credential, telling reauthenticateOnce a 401/403 is
+	// terminal rather than something a retry can fix.
+	errReauthenticateNotSupported = errors.New("reauthenticate not supported for this auth provider")
 )
 
 // New create a new client
@@ -45,8 +67,12 @@ func New(config types.AdGuardInstance) (Client, error) {
 		cl.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
 	}
 
-	if config.Username != "" && config.Password != "" {
-		cl = cl.SetBasicAuth(config.Username, config.Password)
+	rootURL := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+	auth := newAuthProvider(config, rootURL)
+	if auth != nil {
+		if err := auth.Apply(cl); err != nil {
+			return nil, fmt.Errorf("error authenticating: %w", err)
+		}
 	}
 
 	if v, ok := os.LookupEnv(envRedirectPolicyNoOfRedirects); ok {
@@ -60,13 +86,156 @@ func New(config types.AdGuardInstance) (Client, error) {
 		cl.SetRedirectPolicy(resty.NoRedirectPolicy())
 	}
 
+	maxRetries := intSetting(config.MaxRetries, envMaxRetries, defaultMaxRetries)
+	retryBaseDelay := durationSetting(config.RetryBaseDelay, envRetryBaseDelay, defaultRetryBaseDelay)
+
 	return &client{
-		host:   u.Host,
-		client: cl,
-		log:    l.With("host", u.Host),
+		host:    u.Host,
+		client:  cl,
+		log:     l.With("host", u.Host),
+		auth:    auth,
+		limiter: newRateLimiter(floatSetting(config.RPS, envRPS, 0)),
+		breaker: newCircuitBreaker(
+			intSetting(config.BreakerThreshold, envBreakerThreshold, 0),
+			durationSetting(config.BreakerWindow, envBreakerWindow, defaultBreakerWindow),
+		),
+		maxRetries:     maxRetries,
+		retryBaseDelay: retryBaseDelay,
+		retryMaxDelay:  retryBaseDelay << maxRetries,
 	}, nil
 }
 
+// intSetting resolves an integer knob: the instance config value if set,
+// otherwise the env var, otherwise def.
+func intSetting(configValue int, envName string, def int) int {
+	if configValue > 0 {
+		return configValue
+	}
+	if v, ok := os.LookupEnv(envName); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// floatSetting resolves a float knob the same way as intSetting.
+func floatSetting(configValue float64, envName string, def float64) float64 {
+	if configValue > 0 {
+		return configValue
+	}
+	if v, ok := os.LookupEnv(envName); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// durationSetting resolves a duration knob the same way as intSetting.
+func durationSetting(configValue time.Duration, envName string, def time.Duration) time.Duration {
+	if configValue > 0 {
+		return configValue
+	}
+	if v, ok := os.LookupEnv(envName); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// AuthProvider wires an authentication mechanism into the resty client used
+// to talk to an AdGuardHome instance, and knows how to recover when the
+// server rejects a request as unauthenticated.
+type AuthProvider interface {
+	// Apply configures cl to authenticate outgoing requests.
+	Apply(cl *resty.Client) error
+	// Reauthenticate is called after a request comes back 401/403. Providers
+	// backed by a renewable credential (e.g. a session cookie) re-establish
+	// it here so the caller can retry the request.
+	Reauthenticate(cl *resty.Client) error
+}
+
+// newAuthProvider selects the AuthProvider for config, preferring a static
+// API token, then cookie-session login, falling back to HTTP Basic auth.
+// It returns nil if no credentials are configured.
+func newAuthProvider(config types.AdGuardInstance, rootURL string) AuthProvider {
+	switch {
+	case config.APIKey != "":
+		return &apiKeyAuthProvider{token: config.APIKey}
+	case config.SessionAuth:
+		return &cookieSessionAuthProvider{username: config.Username, password: config.Password, loginURL: rootURL + "/login"}
+	case config.Username != "" && config.Password != "":
+		return &basicAuthProvider{username: config.Username, password: config.Password}
+	default:
+		return nil
+	}
+}
+
+// basicAuthProvider authenticates via HTTP Basic auth.
+type basicAuthProvider struct {
+	username string
+	password string
+}
+
+func (p *basicAuthProvider) Apply(cl *resty.Client) error {
+	cl.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+// Reauthenticate is a no-op: Basic auth credentials are static, so a 401/403
+// will never clear by retrying, and reauthenticateOnce treats this error as
+// "don't retry".
+func (p *basicAuthProvider) Reauthenticate(_ *resty.Client) error {
+	return errReauthenticateNotSupported
+}
+
+// apiKeyAuthProvider authenticates with a static bearer token, for instances
+// that sit behind a reverse proxy rejecting HTTP Basic auth.
+type apiKeyAuthProvider struct {
+	token string
+}
+
+func (p *apiKeyAuthProvider) Apply(cl *resty.Client) error {
+	cl.SetAuthToken(p.token)
+	return nil
+}
+
+// Reauthenticate is a no-op: the API token is static, so a 401/403 will
+// never clear by retrying, and reauthenticateOnce treats this error as
+// "don't retry".
+func (p *apiKeyAuthProvider) Reauthenticate(_ *resty.Client) error {
+	return errReauthenticateNotSupported
+}
+
+// cookieSessionAuthProvider authenticates by logging in against /login and
+// relying on resty's cookie jar to attach the returned session cookie to
+// subsequent requests. It logs in again whenever a request comes back
+// unauthenticated, e.g. after the session has expired on the server.
+type cookieSessionAuthProvider struct {
+	username string
+	password string
+	loginURL string
+}
+
+func (p *cookieSessionAuthProvider) Apply(cl *resty.Client) error {
+	return p.Reauthenticate(cl)
+}
+
+func (p *cookieSessionAuthProvider) Reauthenticate(cl *resty.Client) error {
+	resp, err := cl.R().
+		SetBody(map[string]string{"name": p.username, "password": p.password}).
+		Post(p.loginURL)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("login failed: %s", resp.Status())
+	}
+	return nil
+}
+
 // Client AdguardHome API client interface
 type Client interface {
 	Host() string
@@ -74,11 +243,15 @@ type Client interface {
 	ToggleProtection(enable bool) error
 	RewriteList() (*types.RewriteEntries, error)
 	AddRewriteEntries(e ...types.RewriteEntry) error
+	BatchAddRewriteEntries(e ...types.RewriteEntry) error
 	DeleteRewriteEntries(e ...types.RewriteEntry) error
+	BatchDeleteRewriteEntries(e ...types.RewriteEntry) error
 	Filtering() (*types.FilteringStatus, error)
 	ToggleFiltering(enabled bool, interval float64) error
 	AddFilters(whitelist bool, e ...types.Filter) error
+	BatchAddFilters(whitelist bool, e ...types.Filter) error
 	DeleteFilters(whitelist bool, e ...types.Filter) error
+	BatchDeleteFilters(whitelist bool, e ...types.Filter) error
 	UpdateFilters(whitelist bool, e ...types.Filter) error
 	RefreshFilters(whitelist bool) error
 	SetCustomRules(rules types.UserRules) error
@@ -89,12 +262,16 @@ type Client interface {
 	SafeSearch() (bool, error)
 	ToggleSafeSearch(enable bool) error
 	// ------------------------------------------------
-	BlockedServices() (model.BlockedServicesArray, error)
-	SetBlockedServices(model.BlockedServicesArray) error
+	BlockedServices() (*model.BlockedServicesSchedule, error)
+	SetBlockedServices(*model.BlockedServicesSchedule) error
 	Clients() (*model.Clients, error)
+	FindClients(ips ...netip.Addr) ([]model.ClientInfo, error)
 	AddClients(...model.Client) error
+	BatchAddClients(...model.Client) error
 	UpdateClients(...model.Client) error
+	BatchUpdateClients(...model.Client) error
 	DeleteClients(...string) error
+	BatchDeleteClients(...string) error
 	QueryLogConfig() (*model.QueryLogConfig, error)
 	SetQueryLogConfig(enabled bool, interval model.QueryLogConfigInterval, anonymizeClientIP bool) error
 	StatsConfig() (*model.StatsConfig, error)
@@ -107,13 +284,143 @@ type Client interface {
 	DHCPStatus() (*model.DhcpStatus, error)
 	SetDHCPConfig(*model.DhcpConfig) error
 	AddDHCPStaticLeases(leases ...model.DhcpStaticLease) error
+	BatchAddDHCPStaticLeases(leases ...model.DhcpStaticLease) error
 	DeleteDHCPStaticLeases(leases ...model.DhcpStaticLease) error
+	BatchDeleteDHCPStaticLeases(leases ...model.DhcpStaticLease) error
+	QueryLog(params ...QueryLogParam) (*model.QueryLog, error)
+	Stats() (*model.Stats, error)
 }
 
 type client struct {
-	client *resty.Client
-	log    *zap.SugaredLogger
-	host   string
+	client  *resty.Client
+	log     *zap.SugaredLogger
+	host    string
+	auth    AuthProvider
+	limiter *rateLimiter
+	breaker *circuitBreaker
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	versionMu sync.Mutex
+	version   string
+	versionAt time.Time
+}
+
+// rateLimiter is a minimal token-bucket limiter capping the request rate
+// against a single AdGuardHome instance. A nil *rateLimiter is unlimited.
+type rateLimiter struct {
+	tokens chan struct{}
+}
+
+// newRateLimiter creates a limiter refilling at rps tokens per second. It
+// returns nil, meaning unlimited, if rps is not positive.
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+	rl := &rateLimiter{tokens: make(chan struct{}, burst)}
+	for i := 0; i < burst; i++ {
+		rl.tokens <- struct{}{}
+	}
+	go rl.refill(time.Duration(float64(time.Second) / rps))
+	return rl
+}
+
+func (rl *rateLimiter) refill(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		select {
+		case rl.tokens <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wait blocks until a token is available. A nil receiver never blocks.
+func (rl *rateLimiter) wait() {
+	if rl == nil {
+		return
+	}
+	<-rl.tokens
+}
+
+// errCircuitOpen is returned by doGet/doPost while a circuitBreaker is open.
+var errCircuitOpen = errors.New("circuit breaker open: too many consecutive failures")
+
+// circuitBreaker short-circuits further requests to an instance once
+// threshold consecutive failures have been observed, for window, so a
+// momentary or sustained outage does not keep being hammered with retries. A
+// nil *circuitBreaker is always closed.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// newCircuitBreaker creates a breaker that opens after threshold consecutive
+// failures, staying open for window. It returns nil, meaning always closed,
+// if threshold is not positive.
+func newCircuitBreaker(threshold int, window time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, window: window}
+}
+
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if time.Now().Before(cb.openUntil) {
+		return errCircuitOpen
+	}
+	return nil
+}
+
+func (cb *circuitBreaker) recordResult(success bool) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if success {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openUntil = time.Now().Add(cb.window)
+	}
+}
+
+// reauthenticateOnce re-establishes authentication once if resp indicates
+// the request was unauthenticated and an AuthProvider is configured. It
+// reports whether reauthentication succeeded, i.e. whether the request is
+// worth retrying; a failed or unsupported reauthentication reports false.
+func (cl *client) reauthenticateOnce(resp *resty.Response) bool {
+	if cl.auth == nil || resp == nil {
+		return false
+	}
+	if resp.StatusCode() != http.StatusUnauthorized && resp.StatusCode() != http.StatusForbidden {
+		return false
+	}
+	if err := cl.auth.Reauthenticate(cl.client); err != nil {
+		cl.log.With("error", err).Debug("error reauthenticating")
+		return false
+	}
+	return true
 }
 
 func (cl *client) Host() string {
@@ -126,7 +433,11 @@ func (cl *client) doGet(req *resty.Request, url string) error {
 		rl = rl.With("username", cl.client.UserInfo.Username)
 	}
 	rl.Debug("do get")
-	resp, err := req.Get(url)
+	resp, err := cl.executeWithResilience(func() (*resty.Response, error) { return req.Get(url) })
+	if errors.Is(err, errCircuitOpen) {
+		rl.With("error", err).Debug("circuit breaker open, skipping request")
+		return err
+	}
 	if err != nil {
 		if resp != nil && resp.StatusCode() == http.StatusFound {
 			loc := resp.Header().Get("Location")
@@ -137,6 +448,17 @@ func (cl *client) doGet(req *resty.Request, url string) error {
 		rl.With("status", resp.StatusCode(), "body", string(resp.Body()), "error", err).Debug("error in do get")
 		return err
 	}
+	if cl.reauthenticateOnce(resp) {
+		resp, err = cl.executeWithResilience(func() (*resty.Response, error) { return req.Get(url) })
+		if err != nil {
+			if errors.Is(err, errCircuitOpen) {
+				rl.With("error", err).Debug("circuit breaker open, skipping request")
+				return err
+			}
+			rl.With("status", resp.StatusCode(), "body", string(resp.Body()), "error", err).Debug("error in do get")
+			return err
+		}
+	}
 	rl.With("status", resp.StatusCode(), "body", string(resp.Body())).Debug("got response")
 	if resp.StatusCode() != http.StatusOK {
 		return errors.New(resp.Status())
@@ -150,11 +472,26 @@ func (cl *client) doPost(req *resty.Request, url string) error {
 		rl = rl.With("username", cl.client.UserInfo.Username)
 	}
 	rl.Debug("do post")
-	resp, err := req.Post(url)
+	resp, err := cl.executeWithResilience(func() (*resty.Response, error) { return req.Post(url) })
+	if errors.Is(err, errCircuitOpen) {
+		rl.With("error", err).Debug("circuit breaker open, skipping request")
+		return err
+	}
 	if err != nil {
 		rl.With("status", resp.StatusCode(), "body", string(resp.Body()), "error", err).Debug("error in do post")
 		return err
 	}
+	if cl.reauthenticateOnce(resp) {
+		resp, err = cl.executeWithResilience(func() (*resty.Response, error) { return req.Post(url) })
+		if err != nil {
+			if errors.Is(err, errCircuitOpen) {
+				rl.With("error", err).Debug("circuit breaker open, skipping request")
+				return err
+			}
+			rl.With("status", resp.StatusCode(), "body", string(resp.Body()), "error", err).Debug("error in do post")
+			return err
+		}
+	}
 	rl.With("status", resp.StatusCode(), "body", string(resp.Body())).Debug("got response")
 	if resp.StatusCode() != http.StatusOK {
 		return errors.New(resp.Status())
@@ -162,6 +499,41 @@ func (cl *client) doPost(req *resty.Request, url string) error {
 	return nil
 }
 
+// executeWithResilience gates every attempt of fn through the circuit
+// breaker and rate limiter, retrying with exponential backoff and jitter
+// when fn errors or returns a 5xx, up to cl.maxRetries times. Unlike
+// resty's built-in retry, this makes each individual HTTP attempt -
+// including retries - pass through the limiter and breaker, so a retry
+// storm against a failing instance stays rate-limited.
+func (cl *client) executeWithResilience(fn func() (*resty.Response, error)) (*resty.Response, error) {
+	for try := 0; ; try++ {
+		if err := cl.breaker.allow(); err != nil {
+			return nil, err
+		}
+		cl.limiter.wait()
+		resp, err := fn()
+		retryable := err != nil || (resp != nil && resp.StatusCode() >= http.StatusInternalServerError)
+		cl.breaker.recordResult(!retryable)
+		if !retryable || try >= cl.maxRetries {
+			return resp, err
+		}
+		time.Sleep(retryDelay(try, cl.retryBaseDelay, cl.retryMaxDelay))
+	}
+}
+
+// retryDelay returns a jittered, exponentially increasing delay for the
+// try'th (0-based) retry attempt, capped at max.
+func retryDelay(try int, base, max time.Duration) time.Duration {
+	d := base * time.Duration(int64(1)<<try)
+	if max > 0 && d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
 func (cl *client) Status() (*types.Status, error) {
 	status := &types.Status{}
 	err := cl.doGet(cl.client.R().EnableTrace().SetResult(status), "status")
@@ -175,6 +547,9 @@ func (cl *client) RewriteList() (*types.RewriteEntries, error) {
 }
 
 func (cl *client) AddRewriteEntries(entries ...types.RewriteEntry) error {
+	if cl.supportsBatch() {
+		return cl.BatchAddRewriteEntries(entries...)
+	}
 	for i := range entries {
 		e := entries[i]
 		cl.log.With("domain", e.Domain, "answer", e.Answer).Info("Add rewrite entry")
@@ -186,7 +561,20 @@ func (cl *client) AddRewriteEntries(entries ...types.RewriteEntry) error {
 	return nil
 }
 
+// BatchAddRewriteEntries submits all entries to /rewrite/batch_add in a
+// single request.
+func (cl *client) BatchAddRewriteEntries(entries ...types.RewriteEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	cl.log.With("count", len(entries)).Info("Add rewrite entries")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(entries), "/rewrite/batch_add")
+}
+
 func (cl *client) DeleteRewriteEntries(entries ...types.RewriteEntry) error {
+	if cl.supportsBatch() {
+		return cl.BatchDeleteRewriteEntries(entries...)
+	}
 	for i := range entries {
 		e := entries[i]
 		cl.log.With("domain", e.Domain, "answer", e.Answer).Info("Delete rewrite entry")
@@ -198,6 +586,16 @@ func (cl *client) DeleteRewriteEntries(entries ...types.RewriteEntry) error {
 	return nil
 }
 
+// BatchDeleteRewriteEntries submits all entries to /rewrite/batch_delete in
+// a single request.
+func (cl *client) BatchDeleteRewriteEntries(entries ...types.RewriteEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	cl.log.With("count", len(entries)).Info("Delete rewrite entries")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(entries), "/rewrite/batch_delete")
+}
+
 func (cl *client) SafeBrowsing() (bool, error) {
 	return cl.toggleStatus("safebrowsing")
 }
@@ -246,6 +644,9 @@ func (cl *client) Filtering() (*types.FilteringStatus, error) {
 }
 
 func (cl *client) AddFilters(whitelist bool, filters ...types.Filter) error {
+	if cl.supportsBatch() {
+		return cl.BatchAddFilters(whitelist, filters...)
+	}
 	for _, f := range filters {
 		cl.log.With("url", f.URL, "whitelist", whitelist, "enabled", f.Enabled).Info("Add filter")
 		ff := &types.Filter{Name: f.Name, URL: f.URL, Whitelist: whitelist}
@@ -257,7 +658,24 @@ func (cl *client) AddFilters(whitelist bool, filters ...types.Filter) error {
 	return nil
 }
 
+// BatchAddFilters submits all filters to /filtering/batch_add_url in a
+// single request.
+func (cl *client) BatchAddFilters(whitelist bool, filters ...types.Filter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	ff := make([]types.Filter, len(filters))
+	for i, f := range filters {
+		ff[i] = types.Filter{Name: f.Name, URL: f.URL, Whitelist: whitelist}
+	}
+	cl.log.With("count", len(ff), "whitelist", whitelist).Info("Add filters")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(ff), "/filtering/batch_add_url")
+}
+
 func (cl *client) DeleteFilters(whitelist bool, filters ...types.Filter) error {
+	if cl.supportsBatch() {
+		return cl.BatchDeleteFilters(whitelist, filters...)
+	}
 	for _, f := range filters {
 		cl.log.With("url", f.URL, "whitelist", whitelist, "enabled", f.Enabled).Info("Delete filter")
 		ff := &types.Filter{URL: f.URL, Whitelist: whitelist}
@@ -269,6 +687,20 @@ func (cl *client) DeleteFilters(whitelist bool, filters ...types.Filter) error {
 	return nil
 }
 
+// BatchDeleteFilters submits all filters to /filtering/batch_remove_url in
+// a single request.
+func (cl *client) BatchDeleteFilters(whitelist bool, filters ...types.Filter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	ff := make([]types.Filter, len(filters))
+	for i, f := range filters {
+		ff[i] = types.Filter{URL: f.URL, Whitelist: whitelist}
+	}
+	cl.log.With("count", len(ff), "whitelist", whitelist).Info("Delete filters")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(ff), "/filtering/batch_remove_url")
+}
+
 func (cl *client) UpdateFilters(whitelist bool, filters ...types.Filter) error {
 	for _, f := range filters {
 		cl.log.With("url", f.URL, "whitelist", whitelist, "enabled", f.Enabled).Info("Update filter")
@@ -304,15 +736,33 @@ func (cl *client) ToggleFiltering(enabled bool, interval float64) error {
 	}), "/filtering/config")
 }
 
-func (cl *client) BlockedServices() (model.BlockedServicesArray, error) {
-	svcs := model.BlockedServicesArray{}
-	err := cl.doGet(cl.client.R().EnableTrace().SetResult(&svcs), "/blocked_services/list")
+// BlockedServices returns the globally blocked services together with their
+// weekly schedule, if one is configured. Instances that predate the
+// schedule-aware endpoints fall back to the plain ID list, with no schedule.
+func (cl *client) BlockedServices() (*model.BlockedServicesSchedule, error) {
+	if !cl.supportsBlockedServicesSchedule() {
+		ids := model.BlockedServicesArray{}
+		err := cl.doGet(cl.client.R().EnableTrace().SetResult(&ids), "/blocked_services/list")
+		return &model.BlockedServicesSchedule{Ids: ids}, err
+	}
+	svcs := &model.BlockedServicesSchedule{}
+	err := cl.doGet(cl.client.R().EnableTrace().SetResult(svcs), "/blocked_services/get")
 	return svcs, err
 }
 
-func (cl *client) SetBlockedServices(services model.BlockedServicesArray) error {
-	cl.log.With("services", len(services)).Info("Set services")
-	return cl.doPost(cl.client.R().EnableTrace().SetBody(&services), "/blocked_services/set")
+// SetBlockedServices sets the globally blocked services and their weekly
+// schedule. Instances that predate the schedule-aware endpoints get just
+// the ID list; any configured schedule is silently unsupported there.
+func (cl *client) SetBlockedServices(services *model.BlockedServicesSchedule) error {
+	cl.log.Info("Set services")
+	if !cl.supportsBlockedServicesSchedule() {
+		var ids model.BlockedServicesArray
+		if services != nil {
+			ids = services.Ids
+		}
+		return cl.doPost(cl.client.R().EnableTrace().SetBody(&ids), "/blocked_services/set")
+	}
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(services), "/blocked_services/update")
 }
 
 func (cl *client) Clients() (*model.Clients, error) {
@@ -321,9 +771,28 @@ func (cl *client) Clients() (*model.Clients, error) {
 	return clients, err
 }
 
+// FindClients looks up, via /clients/find, both manual and runtime/auto-
+// detected (e.g. DHCP- or WHOIS-discovered) clients matching ips. It is a
+// plain client accessor: promoting a runtime client to a persistent one on
+// another instance, or any other use of this in a sync pass, is left to the
+// caller.
+func (cl *client) FindClients(ips ...netip.Addr) ([]model.ClientInfo, error) {
+	ipStrings := make([]string, len(ips))
+	for i, ip := range ips {
+		ipStrings[i] = ip.String()
+	}
+	var clients []model.ClientInfo
+	err := cl.doPost(cl.client.R().EnableTrace().SetBody(ipStrings).SetResult(&clients), "/clients/find")
+	return clients, err
+}
+
 func (cl *client) AddClients(clients ...model.Client) error {
+	supportsIds, supportsBatch := cl.clientCapabilities()
+	if supportsBatch {
+		return cl.addClients(clients, !supportsIds)
+	}
 	for i := range clients {
-		client := clients[i]
+		client := cl.normalizeClientIds(clients[i], !supportsIds)
 		cl.log.With("name", client.Name).Info("Add client")
 		err := cl.doPost(cl.client.R().EnableTrace().SetBody(&client), "/clients/add")
 		if err != nil {
@@ -333,9 +802,31 @@ func (cl *client) AddClients(clients ...model.Client) error {
 	return nil
 }
 
+// BatchAddClients submits all clients to /clients/batch_add in a single
+// request, normalizing ids/ip/mac for each first.
+func (cl *client) BatchAddClients(clients ...model.Client) error {
+	return cl.addClients(clients, !cl.supportsClientIds())
+}
+
+func (cl *client) addClients(clients []model.Client, legacy bool) error {
+	if len(clients) == 0 {
+		return nil
+	}
+	normalized := make([]model.Client, len(clients))
+	for i := range clients {
+		normalized[i] = cl.normalizeClientIds(clients[i], legacy)
+	}
+	cl.log.With("count", len(normalized)).Info("Add clients")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(normalized), "/clients/batch_add")
+}
+
 func (cl *client) UpdateClients(clients ...model.Client) error {
+	supportsIds, supportsBatch := cl.clientCapabilities()
+	if supportsBatch {
+		return cl.updateClients(clients, !supportsIds)
+	}
 	for i := range clients {
-		client := clients[i]
+		client := cl.normalizeClientIds(clients[i], !supportsIds)
 		cl.log.With("name", client.Name).Info("Update client")
 		err := cl.doPost(cl.client.R().EnableTrace().SetBody(&model.ClientUpdate{Name: client.Name, Data: &client}), "/clients/update")
 		if err != nil {
@@ -345,7 +836,183 @@ func (cl *client) UpdateClients(clients ...model.Client) error {
 	return nil
 }
 
+// BatchUpdateClients submits all clients to /clients/batch_update in a
+// single request, normalizing ids/ip/mac for each first.
+func (cl *client) BatchUpdateClients(clients ...model.Client) error {
+	return cl.updateClients(clients, !cl.supportsClientIds())
+}
+
+func (cl *client) updateClients(clients []model.Client, legacy bool) error {
+	if len(clients) == 0 {
+		return nil
+	}
+	updates := make([]model.ClientUpdate, len(clients))
+	for i := range clients {
+		normalized := cl.normalizeClientIds(clients[i], legacy)
+		updates[i] = model.ClientUpdate{Name: normalized.Name, Data: &normalized}
+	}
+	cl.log.With("count", len(updates)).Info("Update clients")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(updates), "/clients/batch_update")
+}
+
+// versionCacheTTL bounds how long a resolved instance version is reused by
+// the supportsXxx checks below before being re-queried, so a sync pass
+// touching rewrites, filters, clients and DHCP leases costs at most one
+// Status() round trip instead of one per resource type.
+const versionCacheTTL = 5 * time.Minute
+
+// cachedVersion returns the connected instance's version, querying Status()
+// at most once per versionCacheTTL. It reports false if the version could
+// not be resolved, in which case callers should treat the capability it
+// gates as unsupported.
+func (cl *client) cachedVersion() (string, bool) {
+	cl.versionMu.Lock()
+	defer cl.versionMu.Unlock()
+	if cl.version != "" && time.Since(cl.versionAt) < versionCacheTTL {
+		return cl.version, true
+	}
+	status, err := cl.Status()
+	if err != nil || status == nil {
+		return "", false
+	}
+	cl.version = status.Version
+	cl.versionAt = time.Now()
+	return cl.version, true
+}
+
+// clientCapabilities resolves, from the cached instance version, whether the
+// connected instance understands the unified client-ids field and exposes
+// the bulk client batch endpoints (batch support always implies id
+// support, since minBatchEndpointsVersion postdates minClientIdsVersion).
+func (cl *client) clientCapabilities() (supportsIds, supportsBatch bool) {
+	version, ok := cl.cachedVersion()
+	if !ok {
+		return false, false
+	}
+	return versionAtLeast(version, minClientIdsVersion), versionAtLeast(version, minBatchEndpointsVersion)
+}
+
+// minBlockedServicesScheduleVersion is the first AdGuardHome release
+// exposing the schedule-aware blocked-services endpoints
+// (/blocked_services/get and /blocked_services/update).
+const minBlockedServicesScheduleVersion = "v0.107.0"
+
+// supportsBlockedServicesSchedule reports whether the connected instance
+// exposes the schedule-aware blocked-services endpoints. Older instances
+// fall back to the plain ID list via /blocked_services/list and /set.
+func (cl *client) supportsBlockedServicesSchedule() bool {
+	version, ok := cl.cachedVersion()
+	return ok && versionAtLeast(version, minBlockedServicesScheduleVersion)
+}
+
+// minClientIdsVersion is the first AdGuardHome release whose persistent
+// client API accepts the unified "ids" field (IP, CIDR, MAC or ClientID) in
+// place of the legacy, separate "ip" and "mac" fields.
+const minClientIdsVersion = "v0.108.0"
+
+// supportsClientIds reports whether the connected instance understands the
+// multi-ID persistent-client model.
+func (cl *client) supportsClientIds() bool {
+	version, ok := cl.cachedVersion()
+	return ok && versionAtLeast(version, minClientIdsVersion)
+}
+
+// minBatchEndpointsVersion is the first AdGuardHome release exposing bulk
+// array endpoints for rewrite, client and filter mutations.
+const minBatchEndpointsVersion = "v0.109.0"
+
+// supportsBatch reports whether the connected instance exposes the bulk
+// array endpoints for rewrite/client/filter/DHCP-lease mutations. Older
+// instances fall back to one request per element.
+//
+// The Batch* methods below submit their single bulk request unconditionally
+// and are meant to be called once supportsBatch is known to be true; the
+// non-batch method of the same resource (e.g. AddClients for
+// BatchAddClients) already does that check and falls back for older
+// instances, so callers should generally prefer those.
+func (cl *client) supportsBatch() bool {
+	version, ok := cl.cachedVersion()
+	return ok && versionAtLeast(version, minBatchEndpointsVersion)
+}
+
+// normalizeClientIds adapts a client for the instance it is about to be sent
+// to: modern instances get the unified Ids field populated from the legacy
+// Ip/Mac fields, older instances get Ip/Mac derived back out of Ids.
+func (cl *client) normalizeClientIds(c model.Client, legacy bool) model.Client {
+	if legacy {
+		return toLegacyIPMac(c)
+	}
+	return toClientIds(c)
+}
+
+// toClientIds converts the legacy Ip/Mac fields of a client to the unified
+// Ids array expected by newer AdGuardHome instances. A client that already
+// specifies Ids is left untouched.
+func toClientIds(c model.Client) model.Client {
+	if len(c.Ids) == 0 {
+		if c.Ip != "" {
+			c.Ids = append(c.Ids, c.Ip)
+		}
+		if c.Mac != "" {
+			c.Ids = append(c.Ids, c.Mac)
+		}
+	}
+	return c
+}
+
+// toLegacyIPMac converts the unified Ids array back to the legacy Ip/Mac
+// fields expected by AdGuardHome instances that predate the "ids" field. The
+// first IP-shaped id becomes Ip, the first MAC-shaped id becomes Mac; CIDR
+// and ClientID entries have no legacy equivalent and are dropped.
+func toLegacyIPMac(c model.Client) model.Client {
+	for _, id := range c.Ids {
+		if _, err := net.ParseMAC(id); err == nil {
+			if c.Mac == "" {
+				c.Mac = id
+			}
+			continue
+		}
+		if addr, err := netip.ParseAddr(id); err == nil && c.Ip == "" {
+			c.Ip = addr.String()
+		}
+	}
+	c.Ids = nil
+	return c
+}
+
+// versionAtLeast compares two "v<major>.<minor>.<patch>" AdGuardHome version
+// strings, ignoring any non-numeric pre-release suffix (e.g. "-b123").
+func versionAtLeast(version, min string) bool {
+	vp := versionParts(version)
+	mp := versionParts(min)
+	for i := range mp {
+		var v int
+		if i < len(vp) {
+			v = vp[i]
+		}
+		if v != mp[i] {
+			return v > mp[i]
+		}
+	}
+	return true
+}
+
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(version, "v")
+	fields := strings.SplitN(version, "-", 2)
+	parts := strings.Split(fields[0], ".")
+	out := make([]int, len(parts))
+	for i, p := range parts {
+		n, _ := strconv.Atoi(p)
+		out[i] = n
+	}
+	return out
+}
+
 func (cl *client) DeleteClients(clients ...string) error {
+	if cl.supportsBatch() {
+		return cl.BatchDeleteClients(clients...)
+	}
 	for i := range clients {
 		client := clients[i]
 		cl.log.With("name", client).Info("Delete client")
@@ -357,6 +1024,20 @@ func (cl *client) DeleteClients(clients ...string) error {
 	return nil
 }
 
+// BatchDeleteClients submits all named clients to /clients/batch_delete in
+// a single request.
+func (cl *client) BatchDeleteClients(clients ...string) error {
+	if len(clients) == 0 {
+		return nil
+	}
+	deletes := make([]model.ClientDelete, len(clients))
+	for i := range clients {
+		deletes[i] = model.ClientDelete{Name: &clients[i]}
+	}
+	cl.log.With("count", len(deletes)).Info("Delete clients")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(deletes), "/clients/batch_delete")
+}
+
 func (cl *client) QueryLogConfig() (*model.QueryLogConfig, error) {
 	qlc := &model.QueryLogConfig{}
 	err := cl.doGet(cl.client.R().EnableTrace().SetResult(qlc), "/querylog_info")
@@ -439,6 +1120,9 @@ func (cl *client) SetDHCPConfig(config *model.DhcpConfig) error {
 }
 
 func (cl *client) AddDHCPStaticLeases(leases ...model.DhcpStaticLease) error {
+	if cl.supportsBatch() {
+		return cl.BatchAddDHCPStaticLeases(leases...)
+	}
 	for _, l := range leases {
 		cl.log.With("mac", l.Mac, "ip", l.Ip, "hostname", l.Hostname).Info("Add static dhcp lease")
 		err := cl.doPost(cl.client.R().EnableTrace().SetBody(l), "/dhcp/add_static_lease")
@@ -449,7 +1133,20 @@ func (cl *client) AddDHCPStaticLeases(leases ...model.DhcpStaticLease) error {
 	return nil
 }
 
+// BatchAddDHCPStaticLeases submits all leases to
+// /dhcp/batch_add_static_lease in a single request.
+func (cl *client) BatchAddDHCPStaticLeases(leases ...model.DhcpStaticLease) error {
+	if len(leases) == 0 {
+		return nil
+	}
+	cl.log.With("count", len(leases)).Info("Add static dhcp leases")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(leases), "/dhcp/batch_add_static_lease")
+}
+
 func (cl *client) DeleteDHCPStaticLeases(leases ...model.DhcpStaticLease) error {
+	if cl.supportsBatch() {
+		return cl.BatchDeleteDHCPStaticLeases(leases...)
+	}
 	for _, l := range leases {
 		cl.log.With("mac", l.Mac, "ip", l.Ip, "hostname", l.Hostname).Info("Delete static dhcp lease")
 		err := cl.doPost(cl.client.R().EnableTrace().SetBody(l), "/dhcp/remove_static_lease")
@@ -459,3 +1156,75 @@ func (cl *client) DeleteDHCPStaticLeases(leases ...model.DhcpStaticLease) error
 	}
 	return nil
 }
+
+// BatchDeleteDHCPStaticLeases submits all leases to
+// /dhcp/batch_remove_static_lease in a single request.
+func (cl *client) BatchDeleteDHCPStaticLeases(leases ...model.DhcpStaticLease) error {
+	if len(leases) == 0 {
+		return nil
+	}
+	cl.log.With("count", len(leases)).Info("Delete static dhcp leases")
+	return cl.doPost(cl.client.R().EnableTrace().SetBody(leases), "/dhcp/batch_remove_static_lease")
+}
+
+// QueryLogParam narrows the result of QueryLog. Params are applied as query
+// string parameters on the /querylog request.
+type QueryLogParam func(url.Values)
+
+// QueryLogParamClient filters the query log to entries for the given client
+// IP address or client ID.
+func QueryLogParamClient(client string) QueryLogParam {
+	return func(v url.Values) {
+		v.Set("search", client)
+	}
+}
+
+// QueryLogParamResponseStatus filters the query log by response status, e.g.
+// "filtered", "blocked", "processed".
+func QueryLogParamResponseStatus(status string) QueryLogParam {
+	return func(v url.Values) {
+		v.Set("response_status", status)
+	}
+}
+
+// QueryLogParamOlderThan restricts the query log to entries older than the
+// given RFC3339 timestamp, as returned in the "oldest" field of a previous
+// response, allowing time-window based paging.
+func QueryLogParamOlderThan(timestamp string) QueryLogParam {
+	return func(v url.Values) {
+		v.Set("older_than", timestamp)
+	}
+}
+
+// QueryLogParamLimit restricts the number of returned query log entries.
+func QueryLogParamLimit(limit int) QueryLogParam {
+	return func(v url.Values) {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+}
+
+// QueryLog fetches recent /querylog entries from the instance, optionally
+// narrowed by params. It is a plain client accessor: mirroring this history
+// to a replica, or any other use of it in a sync pass, is left to the
+// caller.
+func (cl *client) QueryLog(params ...QueryLogParam) (*model.QueryLog, error) {
+	v := url.Values{}
+	for _, p := range params {
+		p(v)
+	}
+	ql := &model.QueryLog{}
+	u := "/querylog"
+	if enc := v.Encode(); enc != "" {
+		u = fmt.Sprintf("%s?%s", u, enc)
+	}
+	err := cl.doGet(cl.client.R().EnableTrace().SetResult(ql), u)
+	return ql, err
+}
+
+// Stats fetches aggregated /stats counters from the instance. Like QueryLog,
+// it is a plain client accessor with no sync-side wiring of its own.
+func (cl *client) Stats() (*model.Stats, error) {
+	stats := &model.Stats{}
+	err := cl.doGet(cl.client.R().EnableTrace().SetResult(stats), "/stats")
+	return stats, err
+}
@@ -0,0 +1,471 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakito/adguardhome-sync/pkg/client/model"
+	"github.com/bakito/adguardhome-sync/pkg/types"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version string
+		min     string
+		want    bool
+	}{
+		{"v0.108.0", "v0.108.0", true},
+		{"v0.108.1", "v0.108.0", true},
+		{"v0.107.9", "v0.108.0", false},
+		{"v0.108.0-b1", "v0.108.0", true},
+		{"v1.0.0", "v0.108.0", true},
+		{"v0.108", "v0.108.0", true},
+	}
+	for _, c := range cases {
+		if got := versionAtLeast(c.version, c.min); got != c.want {
+			t.Errorf("versionAtLeast(%q, %q) = %v, want %v", c.version, c.min, got, c.want)
+		}
+	}
+}
+
+func TestToClientIds(t *testing.T) {
+	c := toClientIds(model.Client{Ip: "1.2.3.4", Mac: "aa:bb:cc:dd:ee:ff"})
+	if len(c.Ids) != 2 || c.Ids[0] != "1.2.3.4" || c.Ids[1] != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("unexpected ids: %v", c.Ids)
+	}
+
+	// a client that already carries Ids is left untouched
+	already := toClientIds(model.Client{Ids: []string{"my-client-id"}, Ip: "1.2.3.4"})
+	if len(already.Ids) != 1 || already.Ids[0] != "my-client-id" {
+		t.Fatalf("unexpected ids: %v", already.Ids)
+	}
+}
+
+func TestToLegacyIPMac(t *testing.T) {
+	c := toLegacyIPMac(model.Client{Ids: []string{"1.2.3.4", "aa:bb:cc:dd:ee:ff", "192.168.0.0/24", "my-client-id"}})
+	if c.Ip != "1.2.3.4" {
+		t.Errorf("Ip = %q, want 1.2.3.4", c.Ip)
+	}
+	if c.Mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Mac = %q, want aa:bb:cc:dd:ee:ff", c.Mac)
+	}
+	if c.Ids != nil {
+		t.Errorf("Ids = %v, want nil, CIDR/ClientID entries have no legacy equivalent", c.Ids)
+	}
+}
+
+func TestNewAuthProvider(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   types.AdGuardInstance
+		wantType string
+	}{
+		{"api key takes precedence", types.AdGuardInstance{APIKey: "tok", Username: "u", Password: "p"}, "*client.apiKeyAuthProvider"},
+		{"session auth over basic", types.AdGuardInstance{SessionAuth: true, Username: "u", Password: "p"}, "*client.cookieSessionAuthProvider"},
+		{"basic auth fallback", types.AdGuardInstance{Username: "u", Password: "p"}, "*client.basicAuthProvider"},
+		{"no credentials configured", types.AdGuardInstance{}, "<nil>"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := newAuthProvider(c.config, "http://example.invalid")
+			gotType := "<nil>"
+			if got != nil {
+				gotType = fmt.Sprintf("%T", got)
+			}
+			if gotType != c.wantType {
+				t.Errorf("newAuthProvider() = %s, want %s", gotType, c.wantType)
+			}
+		})
+	}
+}
+
+func TestCookieSessionAuthProviderReauthenticate(t *testing.T) {
+	var gotBody map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	p := &cookieSessionAuthProvider{username: "admin", password: "secret", loginURL: srv.URL + "/login"}
+	if err := p.Reauthenticate(resty.New()); err != nil {
+		t.Fatalf("Reauthenticate() error = %v", err)
+	}
+	if gotBody["name"] != "admin" || gotBody["password"] != "secret" {
+		t.Errorf("login body = %+v, want name=admin password=secret", gotBody)
+	}
+}
+
+func TestCookieSessionAuthProviderReauthenticateFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	p := &cookieSessionAuthProvider{username: "admin", password: "wrong", loginURL: srv.URL + "/login"}
+	if err := p.Reauthenticate(resty.New()); err == nil {
+		t.Fatal("Reauthenticate() error = nil, want error for a rejected login")
+	}
+}
+
+func TestStaticCredentialProvidersReauthenticateIsTerminal(t *testing.T) {
+	providers := map[string]AuthProvider{
+		"basic auth": &basicAuthProvider{username: "u", password: "p"},
+		"api key":    &apiKeyAuthProvider{token: "tok"},
+	}
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			if err := p.Reauthenticate(resty.New()); !errors.Is(err, errReauthenticateNotSupported) {
+				t.Errorf("Reauthenticate() = %v, want errReauthenticateNotSupported, a static credential can never recover from a 401/403", err)
+			}
+		})
+	}
+}
+
+func TestReauthenticateOnceDoesNotRetryStaticCredentials(t *testing.T) {
+	cl := &client{auth: &basicAuthProvider{username: "u", password: "p"}, log: l}
+	resp := &resty.Response{RawResponse: &http.Response{StatusCode: http.StatusUnauthorized}}
+	if retried := cl.reauthenticateOnce(resp); retried {
+		t.Error("reauthenticateOnce() = true, want false for a static-credential provider")
+	}
+}
+
+func TestNewRateLimiterNilWhenUnlimited(t *testing.T) {
+	if rl := newRateLimiter(0); rl != nil {
+		t.Errorf("newRateLimiter(0) = %v, want nil", rl)
+	}
+	if rl := newRateLimiter(-1); rl != nil {
+		t.Errorf("newRateLimiter(-1) = %v, want nil", rl)
+	}
+}
+
+func TestNilRateLimiterWaitNeverBlocks(t *testing.T) {
+	var rl *rateLimiter
+	done := make(chan struct{})
+	go func() {
+		rl.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait() on a nil *rateLimiter blocked")
+	}
+}
+
+func TestRateLimiterLimitsBurst(t *testing.T) {
+	rl := newRateLimiter(1)
+	// the initial burst token is consumed immediately
+	rl.wait()
+
+	done := make(chan struct{})
+	go func() {
+		rl.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second wait() returned before the limiter refilled")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-done
+}
+
+func TestNewCircuitBreakerNilWhenDisabled(t *testing.T) {
+	if cb := newCircuitBreaker(0, time.Second); cb != nil {
+		t.Errorf("newCircuitBreaker(0, ...) = %v, want nil", cb)
+	}
+}
+
+func TestNilCircuitBreakerAlwaysClosed(t *testing.T) {
+	var cb *circuitBreaker
+	if err := cb.allow(); err != nil {
+		t.Errorf("allow() on a nil *circuitBreaker = %v, want nil", err)
+	}
+	cb.recordResult(false)
+	if err := cb.allow(); err != nil {
+		t.Errorf("allow() on a nil *circuitBreaker = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerOpensAfterThresholdFailures(t *testing.T) {
+	cb := newCircuitBreaker(2, 50*time.Millisecond)
+
+	cb.recordResult(false)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("allow() after 1 failure = %v, want nil", err)
+	}
+
+	cb.recordResult(false)
+	if err := cb.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow() after reaching threshold = %v, want errCircuitOpen", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := cb.allow(); err != nil {
+		t.Errorf("allow() after window elapsed = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerResetsOnSuccess(t *testing.T) {
+	cb := newCircuitBreaker(2, 50*time.Millisecond)
+
+	cb.recordResult(false)
+	cb.recordResult(true)
+	cb.recordResult(false)
+	if err := cb.allow(); err != nil {
+		t.Errorf("allow() = %v, want nil, a success should reset the failure streak", err)
+	}
+}
+
+// newTestClient starts a fake AdGuardHome instance whose /control/status
+// endpoint reports version, and returns the *client talking to it.
+func newTestClient(t *testing.T, version string) *client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"version": version})
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c.(*client)
+}
+
+func TestClientCapabilities(t *testing.T) {
+	cases := []struct {
+		version      string
+		wantIds      bool
+		wantBatch    bool
+		wantSupports bool // supportsBatch() should agree with the batch flag above
+	}{
+		{"v0.107.9", false, false, false},
+		{"v0.108.0", true, false, false},
+		{"v0.108.5", true, false, false},
+		{"v0.109.0", true, true, true},
+		{"v0.110.0", true, true, true},
+	}
+	for _, c := range cases {
+		t.Run(c.version, func(t *testing.T) {
+			cl := newTestClient(t, c.version)
+			gotIds, gotBatch := cl.clientCapabilities()
+			if gotIds != c.wantIds || gotBatch != c.wantBatch {
+				t.Errorf("clientCapabilities() = (%v, %v), want (%v, %v)", gotIds, gotBatch, c.wantIds, c.wantBatch)
+			}
+			if gotSupports := cl.supportsBatch(); gotSupports != c.wantSupports {
+				t.Errorf("supportsBatch() = %v, want %v", gotSupports, c.wantSupports)
+			}
+		})
+	}
+}
+
+func TestClientCapabilitiesOnStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := cl.(*client)
+
+	gotIds, gotBatch := c.clientCapabilities()
+	if gotIds || gotBatch {
+		t.Errorf("clientCapabilities() = (%v, %v), want (false, false) when Status() fails", gotIds, gotBatch)
+	}
+}
+
+func TestCachedVersionReusedAcrossCapabilityChecks(t *testing.T) {
+	var statusCalls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		statusCalls++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"version": "v0.109.0"})
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := cl.(*client)
+
+	_, _ = c.clientCapabilities()
+	c.supportsBatch()
+	c.supportsClientIds()
+	c.supportsBlockedServicesSchedule()
+
+	if statusCalls != 1 {
+		t.Errorf("Status() was called %d times, want 1, the resolved version should be cached across checks", statusCalls)
+	}
+}
+
+func TestSetBlockedServicesNilDoesNotPanic(t *testing.T) {
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/control/status":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{"version": "v0.106.0"})
+		case "/control/blocked_services/set":
+			body, _ := io.ReadAll(r.Body)
+			gotBody = string(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := cl.SetBlockedServices(nil); err != nil {
+		t.Fatalf("SetBlockedServices(nil) error = %v", err)
+	}
+	if body := strings.TrimSpace(gotBody); body != "[]" && body != "null" {
+		t.Errorf("request body = %q, want an empty ID list ([] or null)", gotBody)
+	}
+}
+
+func TestQueryLogParamsEncodeQueryString(t *testing.T) {
+	v := url.Values{}
+	for _, p := range []QueryLogParam{
+		QueryLogParamClient("192.168.1.1"),
+		QueryLogParamResponseStatus("blocked"),
+		QueryLogParamOlderThan("2024-01-01T00:00:00Z"),
+		QueryLogParamLimit(50),
+	} {
+		p(v)
+	}
+	if got := v.Get("search"); got != "192.168.1.1" {
+		t.Errorf("search = %q, want 192.168.1.1", got)
+	}
+	if got := v.Get("response_status"); got != "blocked" {
+		t.Errorf("response_status = %q, want blocked", got)
+	}
+	if got := v.Get("older_than"); got != "2024-01-01T00:00:00Z" {
+		t.Errorf("older_than = %q, want 2024-01-01T00:00:00Z", got)
+	}
+	if got := v.Get("limit"); got != "50" {
+		t.Errorf("limit = %q, want 50", got)
+	}
+}
+
+func TestQueryLogRequestsWithEncodedParams(t *testing.T) {
+	var gotURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := cl.QueryLog(QueryLogParamLimit(10), QueryLogParamResponseStatus("filtered")); err != nil {
+		t.Fatalf("QueryLog() error = %v", err)
+	}
+	if gotURL != "/control/querylog?limit=10&response_status=filtered" {
+		t.Errorf("request URL = %q", gotURL)
+	}
+}
+
+func TestQueryLogWithNoParamsOmitsQueryString(t *testing.T) {
+	var gotURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := cl.QueryLog(); err != nil {
+		t.Fatalf("QueryLog() error = %v", err)
+	}
+	if gotURL != "/control/querylog" {
+		t.Errorf("request URL = %q, want no query string", gotURL)
+	}
+}
+
+func TestStatsRequestsStatsEndpoint(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := cl.Stats(); err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if gotPath != "/control/stats" {
+		t.Errorf("request path = %q, want /control/stats", gotPath)
+	}
+}
+
+func TestFindClientsPostsIPsAndReturnsResult(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{}]`))
+	}))
+	defer srv.Close()
+
+	cl, err := New(types.AdGuardInstance{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	clients, err := cl.FindClients(netip.MustParseAddr("192.168.1.5"), netip.MustParseAddr("192.168.1.6"))
+	if err != nil {
+		t.Fatalf("FindClients() error = %v", err)
+	}
+	if gotPath != "/control/clients/find" {
+		t.Errorf("request path = %q, want /control/clients/find", gotPath)
+	}
+	if want := `["192.168.1.5","192.168.1.6"]`; strings.TrimSpace(gotBody) != want {
+		t.Errorf("request body = %q, want %q", gotBody, want)
+	}
+	if len(clients) != 1 {
+		t.Errorf("clients = %+v, want one result", clients)
+	}
+}